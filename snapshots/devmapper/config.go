@@ -20,6 +20,7 @@ package devmapper
 
 import (
 	"fmt"
+	"net"
 	"os"
 
 	"github.com/docker/go-units"
@@ -46,8 +47,71 @@ type Config struct {
 
 	// Whether to discard blocks when removing a thin device.
 	DiscardBlocks bool `toml:"discard_blocks"`
+
+	// DataAutoExtendThresholdPercent is the data free-space percentage below
+	// which the pool watcher triggers an automatic pool extension. 0 disables
+	// auto-extension of the data device.
+	DataAutoExtendThresholdPercent int `toml:"data_auto_extend_threshold_percent"`
+
+	// DataAutoExtendPercent is how much to grow the data device by, as a
+	// percentage of its current size, when DataAutoExtendThresholdPercent is
+	// crossed.
+	DataAutoExtendPercent int `toml:"data_auto_extend_percent"`
+
+	// MetadataAutoExtendThresholdPercent is the metadata free-space
+	// percentage below which the pool watcher triggers an automatic pool
+	// extension. 0 disables auto-extension of the metadata device.
+	MetadataAutoExtendThresholdPercent int `toml:"metadata_auto_extend_threshold_percent"`
+
+	// MetadataAutoExtendPercent is how much to grow the metadata device by,
+	// as a percentage of its current size, when
+	// MetadataAutoExtendThresholdPercent is crossed.
+	MetadataAutoExtendPercent int `toml:"metadata_auto_extend_percent"`
+
+	// MetricsAddress is the tcp address to serve Prometheus metrics on, e.g.
+	// "localhost:9406". Leave empty to disable the metrics endpoint.
+	MetricsAddress string `toml:"metrics_address"`
+
+	// Filesystem to format thin devices with: "ext4" or "xfs". Defaults to ext4.
+	Filesystem string `toml:"filesystem"`
+
+	// Extra arguments passed to mkfs when formatting a base image device.
+	MkfsArgs []string `toml:"mkfs_args"`
+
+	// Mount options applied when activating thin devices, e.g. "nodiscard",
+	// "discard", "noatime".
+	MountOptions []string `toml:"mount_options"`
+
+	// Filesystem-specific mount options, merged into the mount options set
+	// as "key" (if value is empty) or "key=value", e.g. {"pquota": ""} to
+	// enable xfs project quotas.
+	FsOptions map[string]string `toml:"fs_options"`
+
+	// MinFreeSpacePercent is the minimum percentage of free data space the
+	// pool must retain; allocating a new thin device or snapshot is refused
+	// once it would push free space below this threshold. Defaults to 10.
+	MinFreeSpacePercent int `toml:"min_free_space_percent"`
+
+	// MetadataMinFreeSpacePercent is the minimum percentage of free
+	// metadata space the pool must retain before new allocations are
+	// refused.
+	MetadataMinFreeSpacePercent int `toml:"metadata_min_free_space_percent"`
+
+	// ReserveMetadataBytes is additional metadata headroom, in bytes, kept
+	// aside for deletion/commit operations even under space pressure. dm
+	// thin pools go read-only once metadata fills, and recovery requires an
+	// offline repair, so this reserve is checked in addition to
+	// MetadataMinFreeSpacePercent. It is validated against the pool's total
+	// metadata size once the pool is opened; see Config.ValidateReserve.
+	ReserveMetadataBytes uint64 `toml:"reserve_metadata_bytes"`
 }
 
+// Supported values for Config.Filesystem.
+const (
+	FileSystemExt4 = "ext4"
+	FileSystemXFS  = "xfs"
+)
+
 // LoadConfig reads devmapper configuration file from disk in TOML format
 func LoadConfig(path string) (*Config, error) {
 	if _, err := os.Stat(path); err != nil {
@@ -86,9 +150,34 @@ func (c *Config) parse() error {
 	}
 
 	c.BaseImageSizeBytes = uint64(baseImageSize)
+
+	if c.Filesystem == "" {
+		c.Filesystem = FileSystemExt4
+	}
+
+	if c.MinFreeSpacePercent == 0 {
+		c.MinFreeSpacePercent = 10
+	}
+
+	c.MountOptions = dedupeStrings(c.MountOptions)
+
 	return nil
 }
 
+// dedupeStrings returns items with duplicates removed, preserving order.
+func dedupeStrings(items []string) []string {
+	seen := make(map[string]struct{}, len(items))
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		if _, ok := seen[item]; ok {
+			continue
+		}
+		seen[item] = struct{}{}
+		out = append(out, item)
+	}
+	return out
+}
+
 // Validate makes sure configuration fields are valid
 func (c *Config) Validate() error {
 	var result *multierror.Error
@@ -105,5 +194,47 @@ func (c *Config) Validate() error {
 		result = multierror.Append(result, fmt.Errorf("base_image_size is required"))
 	}
 
+	for name, percent := range map[string]int{
+		"data_auto_extend_threshold_percent":     c.DataAutoExtendThresholdPercent,
+		"data_auto_extend_percent":               c.DataAutoExtendPercent,
+		"metadata_auto_extend_threshold_percent": c.MetadataAutoExtendThresholdPercent,
+		"metadata_auto_extend_percent":           c.MetadataAutoExtendPercent,
+	} {
+		if percent < 0 || percent > 100 {
+			result = multierror.Append(result, fmt.Errorf("%s must be between 0 and 100, got %d", name, percent))
+		}
+	}
+
+	if c.MetricsAddress != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddress); err != nil {
+			result = multierror.Append(result, errors.Wrap(err, "metrics_address is invalid"))
+		}
+	}
+
+	switch c.Filesystem {
+	case "", FileSystemExt4, FileSystemXFS:
+	default:
+		result = multierror.Append(result, fmt.Errorf("unsupported filesystem %q: must be %q or %q", c.Filesystem, FileSystemExt4, FileSystemXFS))
+	}
+
+	if err := validateMkfsArgs(c.Filesystem, c.MkfsArgs); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	for opt := range c.FsOptions {
+		if opt == "pquota" && c.Filesystem != FileSystemXFS {
+			result = multierror.Append(result, fmt.Errorf("fs_options %q is only valid for filesystem %q", opt, FileSystemXFS))
+		}
+	}
+
+	for name, percent := range map[string]int{
+		"min_free_space_percent":          c.MinFreeSpacePercent,
+		"metadata_min_free_space_percent": c.MetadataMinFreeSpacePercent,
+	} {
+		if percent < 0 || percent > 99 {
+			result = multierror.Append(result, fmt.Errorf("%s must be between 0 and 99, got %d", name, percent))
+		}
+	}
+
 	return result.ErrorOrNil()
 }
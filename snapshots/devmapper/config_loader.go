@@ -0,0 +1,228 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package devmapper
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// restartRequiredFields are the toml keys that Reload refuses to change in
+// place because applying them requires recreating the pool or snapshotter:
+// PoolName and RootPath identify the on-disk pool/metadata, and Filesystem
+// only takes effect when a device is formatted.
+var restartRequiredFields = map[string]bool{
+	"pool_name":  true,
+	"root_path":  true,
+	"filesystem": true,
+}
+
+// ConfigLoader loads devmapper Config from a fixed path and allows it to be
+// safely reloaded at runtime, e.g. in response to SIGHUP or an fsnotify
+// watch on the TOML file. All package consumers read configuration through
+// its accessor methods instead of capturing a *Config at startup, so a
+// Reload takes effect immediately for every field that is safe to change
+// without recreating the pool.
+type ConfigLoader struct {
+	path string
+
+	mu      sync.RWMutex
+	current *Config
+}
+
+// NewConfigLoader loads the configuration at path and returns a loader that
+// can later be asked to Reload from the same path.
+func NewConfigLoader(path string) (*ConfigLoader, error) {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConfigLoader{path: path, current: config}, nil
+}
+
+// Config returns a point-in-time copy of the current configuration.
+func (l *ConfigLoader) Config() Config {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return *l.current
+}
+
+// Reload re-reads the configuration from disk and, if every changed field
+// is safe to apply at runtime, swaps it in atomically and returns the toml
+// keys that changed. If any changed field requires a restart, the reload is
+// rejected with an error naming the offending keys and the current
+// configuration is left untouched.
+func (l *ConfigLoader) Reload() ([]string, error) {
+	next, err := LoadConfig(l.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to reload devmapper config")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	changed := diffConfig(l.current, next)
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	var restart []string
+	for _, field := range changed {
+		if restartRequiredFields[field] {
+			restart = append(restart, field)
+		}
+	}
+
+	if len(restart) > 0 {
+		return nil, errors.Errorf("cannot reload devmapper config: %v require a restart", restart)
+	}
+
+	l.current = next
+	return changed, nil
+}
+
+// diffConfig returns the toml keys whose values differ between old and
+// updated.
+func diffConfig(old, updated *Config) []string {
+	var changed []string
+
+	add := func(field string, eq bool) {
+		if !eq {
+			changed = append(changed, field)
+		}
+	}
+
+	add("root_path", old.RootPath == updated.RootPath)
+	add("pool_name", old.PoolName == updated.PoolName)
+	add("base_image_size", old.BaseImageSizeBytes == updated.BaseImageSizeBytes)
+	add("async_remove", old.AsyncRemove == updated.AsyncRemove)
+	add("discard_blocks", old.DiscardBlocks == updated.DiscardBlocks)
+	add("data_auto_extend_threshold_percent", old.DataAutoExtendThresholdPercent == updated.DataAutoExtendThresholdPercent)
+	add("data_auto_extend_percent", old.DataAutoExtendPercent == updated.DataAutoExtendPercent)
+	add("metadata_auto_extend_threshold_percent", old.MetadataAutoExtendThresholdPercent == updated.MetadataAutoExtendThresholdPercent)
+	add("metadata_auto_extend_percent", old.MetadataAutoExtendPercent == updated.MetadataAutoExtendPercent)
+	add("metrics_address", old.MetricsAddress == updated.MetricsAddress)
+	add("filesystem", old.Filesystem == updated.Filesystem)
+	add("mkfs_args", stringSlicesEqual(old.MkfsArgs, updated.MkfsArgs))
+	add("mount_options", stringSlicesEqual(old.MountOptions, updated.MountOptions))
+	add("fs_options", stringMapsEqual(old.FsOptions, updated.FsOptions))
+	add("min_free_space_percent", old.MinFreeSpacePercent == updated.MinFreeSpacePercent)
+	add("metadata_min_free_space_percent", old.MetadataMinFreeSpacePercent == updated.MetadataMinFreeSpacePercent)
+	add("reserve_metadata_bytes", old.ReserveMetadataBytes == updated.ReserveMetadataBytes)
+
+	return changed
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func stringMapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Accessor methods below are used by package consumers (ThinPoolWatcher,
+// the metrics collectors) in place of capturing a *Config at construction
+// time, so that a Reload takes effect without recreating them.
+
+// BaseImageSizeBytes returns the currently configured base image size.
+func (l *ConfigLoader) BaseImageSizeBytes() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.current.BaseImageSizeBytes
+}
+
+// AsyncRemove reports whether devices are currently removed asynchronously.
+func (l *ConfigLoader) AsyncRemove() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.current.AsyncRemove
+}
+
+// DiscardBlocks reports whether blocks are currently discarded on removal.
+func (l *ConfigLoader) DiscardBlocks() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.current.DiscardBlocks
+}
+
+// DataAutoExtend returns the current data auto-extend threshold and
+// increment, both percentages.
+func (l *ConfigLoader) DataAutoExtend() (thresholdPercent, extendPercent int) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.current.DataAutoExtendThresholdPercent, l.current.DataAutoExtendPercent
+}
+
+// MetadataAutoExtend returns the current metadata auto-extend threshold and
+// increment, both percentages.
+func (l *ConfigLoader) MetadataAutoExtend() (thresholdPercent, extendPercent int) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.current.MetadataAutoExtendThresholdPercent, l.current.MetadataAutoExtendPercent
+}
+
+// MinFreeSpacePercent returns the current minimum free data space
+// percentage enforced on new allocations.
+func (l *ConfigLoader) MinFreeSpacePercent() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.current.MinFreeSpacePercent
+}
+
+// MetadataMinFreeSpacePercent returns the current minimum free metadata
+// space percentage enforced on new allocations.
+func (l *ConfigLoader) MetadataMinFreeSpacePercent() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.current.MetadataMinFreeSpacePercent
+}
+
+// ReserveMetadataBytes returns the current metadata reserve, in bytes.
+func (l *ConfigLoader) ReserveMetadataBytes() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.current.ReserveMetadataBytes
+}
+
+// MountOptionsString returns the mount options string to apply to devices
+// mounted from this point on; mounts already in place are unaffected.
+func (l *ConfigLoader) MountOptionsString() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.current.MountOptionsString()
+}
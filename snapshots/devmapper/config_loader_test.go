@@ -0,0 +1,164 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package devmapper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestDiffConfig(t *testing.T) {
+	base := Config{
+		RootPath:            "/var/lib/devmapper",
+		PoolName:            "pool",
+		BaseImageSizeBytes:  8 << 30,
+		AsyncRemove:         false,
+		MinFreeSpacePercent: 10,
+		MountOptions:        []string{"noatime", "nodiscard"},
+		FsOptions:           map[string]string{"pquota": ""},
+	}
+
+	for _, testcase := range []struct {
+		name   string
+		mutate func(c *Config)
+		want   []string
+	}{
+		{name: "no changes", mutate: func(c *Config) {}, want: nil},
+		{name: "async_remove", mutate: func(c *Config) { c.AsyncRemove = true }, want: []string{"async_remove"}},
+		{name: "pool_name", mutate: func(c *Config) { c.PoolName = "other" }, want: []string{"pool_name"}},
+		{
+			name:   "mount_options reordered is a change",
+			mutate: func(c *Config) { c.MountOptions = []string{"nodiscard", "noatime"} },
+			want:   []string{"mount_options"},
+		},
+		{
+			name:   "fs_options value changed",
+			mutate: func(c *Config) { c.FsOptions = map[string]string{"pquota": "x"} },
+			want:   []string{"fs_options"},
+		},
+		{
+			name: "multiple fields",
+			mutate: func(c *Config) {
+				c.PoolName = "other"
+				c.MinFreeSpacePercent = 20
+			},
+			want: []string{"pool_name", "min_free_space_percent"},
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			updated := base
+			testcase.mutate(&updated)
+
+			got := diffConfig(&base, &updated)
+			sort.Strings(got)
+
+			want := append([]string(nil), testcase.want...)
+			sort.Strings(want)
+
+			if len(got) != len(want) {
+				t.Fatalf("diffConfig() = %v, want %v", got, want)
+			}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Fatalf("diffConfig() = %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func writeTestConfig(t *testing.T, path, poolName string, asyncRemove bool) {
+	t.Helper()
+
+	contents := "root_path = \"/var/lib/devmapper\"\n" +
+		"pool_name = \"" + poolName + "\"\n" +
+		"base_image_size = \"8GB\"\n"
+	if asyncRemove {
+		contents += "async_remove = true\n"
+	}
+
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+}
+
+func TestConfigLoaderReloadSafeField(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devmapper-config-loader")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.toml")
+	writeTestConfig(t, path, "pool", false)
+
+	loader, err := NewConfigLoader(path)
+	if err != nil {
+		t.Fatalf("NewConfigLoader() error: %v", err)
+	}
+
+	if loader.AsyncRemove() != false {
+		t.Fatalf("AsyncRemove() = true, want false")
+	}
+
+	writeTestConfig(t, path, "pool", true)
+
+	changed, err := loader.Reload()
+	if err != nil {
+		t.Fatalf("Reload() error: %v", err)
+	}
+
+	if len(changed) != 1 || changed[0] != "async_remove" {
+		t.Fatalf("Reload() changed = %v, want [async_remove]", changed)
+	}
+
+	if !loader.AsyncRemove() {
+		t.Fatal("AsyncRemove() = false after reload, want true")
+	}
+}
+
+func TestConfigLoaderReloadRestartRequiredField(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devmapper-config-loader")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.toml")
+	writeTestConfig(t, path, "pool", false)
+
+	loader, err := NewConfigLoader(path)
+	if err != nil {
+		t.Fatalf("NewConfigLoader() error: %v", err)
+	}
+
+	writeTestConfig(t, path, "other-pool", false)
+
+	if _, err := loader.Reload(); err == nil {
+		t.Fatal("Reload() with a changed pool_name should fail, got nil error")
+	}
+
+	got := loader.Config()
+	if got.PoolName != "pool" {
+		t.Fatalf("PoolName = %q after rejected reload, want unchanged %q", got.PoolName, "pool")
+	}
+}
@@ -0,0 +1,78 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package devmapper
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// validateMkfsArgs rejects mkfs_args that contradict the filesystem
+// containerd will format the device with: the mkfs binary is already
+// selected from Config.Filesystem (mkfs.ext4 / mkfs.xfs), so an explicit
+// "-t"/"--type" argument can only disagree with it.
+func validateMkfsArgs(filesystem string, args []string) error {
+	for _, arg := range args {
+		if arg == "-t" || arg == "--type" {
+			return errors.Errorf("mkfs_args must not include %q: the mkfs binary is already selected by the filesystem setting (%s)", arg, filesystem)
+		}
+	}
+	return nil
+}
+
+// MkfsBinary returns the mkfs binary used to format base image devices for
+// the configured filesystem, e.g. "mkfs.ext4".
+func (c *Config) MkfsBinary() string {
+	return "mkfs." + c.Filesystem
+}
+
+// MkfsCommandArgs returns the full mkfs argument list, including the
+// configured mkfs_args, to format devicePath. Callers invoke this as
+// exec.Command(c.MkfsBinary(), c.MkfsCommandArgs(devicePath)...).
+func (c *Config) MkfsCommandArgs(devicePath string) []string {
+	args := make([]string, 0, len(c.MkfsArgs)+1)
+	args = append(args, c.MkfsArgs...)
+	args = append(args, devicePath)
+	return args
+}
+
+// MountOptionsString joins the configured mount_options and fs_options into
+// the comma separated argument expected by mount(8)'s "-o" flag.
+func (c *Config) MountOptionsString() string {
+	opts := make([]string, 0, len(c.MountOptions)+len(c.FsOptions))
+	opts = append(opts, c.MountOptions...)
+
+	fsOptKeys := make([]string, 0, len(c.FsOptions))
+	for k := range c.FsOptions {
+		fsOptKeys = append(fsOptKeys, k)
+	}
+	sort.Strings(fsOptKeys)
+
+	for _, k := range fsOptKeys {
+		if v := c.FsOptions[k]; v != "" {
+			opts = append(opts, k+"="+v)
+		} else {
+			opts = append(opts, k)
+		}
+	}
+
+	return strings.Join(opts, ",")
+}
@@ -0,0 +1,116 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package devmapper
+
+import "testing"
+
+func TestValidateMkfsArgs(t *testing.T) {
+	for _, testcase := range []struct {
+		name       string
+		filesystem string
+		args       []string
+		wantErr    bool
+	}{
+		{name: "no args", filesystem: FileSystemExt4, args: nil},
+		{name: "unrelated args", filesystem: FileSystemExt4, args: []string{"-O", "^has_journal"}},
+		{name: "explicit -t conflicts", filesystem: FileSystemXFS, args: []string{"-t", "ext4"}, wantErr: true},
+		{name: "explicit --type conflicts", filesystem: FileSystemExt4, args: []string{"--type", "xfs"}, wantErr: true},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			err := validateMkfsArgs(testcase.filesystem, testcase.args)
+			if testcase.wantErr && err == nil {
+				t.Fatal("expected an error, got nil")
+			}
+			if !testcase.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestConfigMountOptionsString(t *testing.T) {
+	for _, testcase := range []struct {
+		name string
+		c    Config
+		want string
+	}{
+		{
+			name: "empty",
+			c:    Config{},
+			want: "",
+		},
+		{
+			name: "mount options only",
+			c:    Config{MountOptions: []string{"noatime", "nodiscard"}},
+			want: "noatime,nodiscard",
+		},
+		{
+			name: "fs options are sorted for determinism",
+			c:    Config{FsOptions: map[string]string{"uquota": "", "pquota": ""}},
+			want: "pquota,uquota",
+		},
+		{
+			name: "fs option with value",
+			c:    Config{FsOptions: map[string]string{"usrjquota": "aquota.user"}},
+			want: "usrjquota=aquota.user",
+		},
+		{
+			name: "mount options and fs options combined",
+			c: Config{
+				MountOptions: []string{"noatime"},
+				FsOptions:    map[string]string{"pquota": ""},
+			},
+			want: "noatime,pquota",
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			if got := testcase.c.MountOptionsString(); got != testcase.want {
+				t.Errorf("MountOptionsString() = %q, want %q", got, testcase.want)
+			}
+		})
+	}
+}
+
+func TestDedupeStrings(t *testing.T) {
+	for _, testcase := range []struct {
+		name  string
+		items []string
+		want  []string
+	}{
+		{name: "nil", items: nil, want: []string{}},
+		{name: "no duplicates", items: []string{"noatime", "nodiscard"}, want: []string{"noatime", "nodiscard"}},
+		{
+			name:  "duplicates removed, order preserved",
+			items: []string{"noatime", "nodiscard", "noatime"},
+			want:  []string{"noatime", "nodiscard"},
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			got := dedupeStrings(testcase.items)
+			if len(got) != len(testcase.want) {
+				t.Fatalf("dedupeStrings() = %v, want %v", got, testcase.want)
+			}
+			for i := range got {
+				if got[i] != testcase.want[i] {
+					t.Errorf("dedupeStrings() = %v, want %v", got, testcase.want)
+				}
+			}
+		})
+	}
+}
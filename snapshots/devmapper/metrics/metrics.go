@@ -0,0 +1,131 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package metrics exposes Prometheus collectors for the devmapper
+// snapshotter: thin-pool space usage and device lifecycle operations.
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/containerd/snapshots/devmapper"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "containerd"
+	subsystem = "devmapper"
+)
+
+// PoolCollector is a prometheus.Collector reporting a thin-pool's space
+// usage. It calls the pool's ThinPoolWatcher.GetUsage lazily on every scrape
+// instead of on a timer of its own. GetUsage returns the watcher's cached
+// usage and only probes the pool when nothing has been cached yet, so
+// attaching a scraper adds no dmsetup load beyond the watcher's own polling.
+type PoolCollector struct {
+	poolName       string
+	watcher        *devmapper.ThinPoolWatcher
+	blockSizeBytes uint64
+	config         *devmapper.ConfigLoader
+
+	dataUsageBytes        *prometheus.Desc
+	dataUsagePercent      *prometheus.Desc
+	metadataUsageBytes    *prometheus.Desc
+	metadataUsagePercent  *prometheus.Desc
+	transactionID         *prometheus.Desc
+	metadataReservedBytes *prometheus.Desc
+}
+
+// NewPoolCollector creates a collector for the given pool and registers it
+// with reg, so callers control which registry (the global default, or one
+// scoped to a single pool) it ends up on. blockSizeBytes is the pool's data
+// block size, used to convert data block counts reported by dmsetup into
+// bytes; the metadata block size is fixed by dm-thin itself, so it is not
+// configurable here. config is consulted on every scrape to report the
+// currently configured metadata reserve.
+func NewPoolCollector(reg prometheus.Registerer, poolName string, watcher *devmapper.ThinPoolWatcher, config *devmapper.ConfigLoader, blockSizeBytes uint64) (*PoolCollector, error) {
+	labels := []string{"pool"}
+
+	c := &PoolCollector{
+		poolName:       poolName,
+		watcher:        watcher,
+		blockSizeBytes: blockSizeBytes,
+		config:         config,
+		dataUsageBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "pool_data_usage_bytes"),
+			"Allocated data space of the thin-pool, in bytes.", labels, nil),
+		dataUsagePercent: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "pool_data_usage_percent"),
+			"Allocated data space of the thin-pool, as a percentage.", labels, nil),
+		metadataUsageBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "pool_metadata_usage_bytes"),
+			"Allocated metadata space of the thin-pool, in bytes.", labels, nil),
+		metadataUsagePercent: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "pool_metadata_usage_percent"),
+			"Allocated metadata space of the thin-pool, as a percentage.", labels, nil),
+		transactionID: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "pool_transaction_id"),
+			"Current transaction id of the thin-pool.", labels, nil),
+		metadataReservedBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, subsystem, "pool_metadata_reserved_bytes"),
+			"Metadata headroom, in bytes, reserved for deletion/commit operations.", labels, nil),
+	}
+
+	if err := reg.Register(c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Describe implements prometheus.Collector.
+func (c *PoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.dataUsageBytes
+	ch <- c.dataUsagePercent
+	ch <- c.metadataUsageBytes
+	ch <- c.metadataUsagePercent
+	ch <- c.transactionID
+	ch <- c.metadataReservedBytes
+}
+
+// Collect implements prometheus.Collector, reading the usage most recently
+// observed by the pool's ThinPoolWatcher on every scrape.
+func (c *PoolCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	usage, err := c.watcher.GetUsage(ctx)
+	if err != nil && usage.TotalDataBlocks == 0 && usage.TotalMetadataBlocks == 0 {
+		// No usage has ever been observed; nothing to report yet.
+		return
+	}
+
+	dataUsed := usage.TotalDataBlocks - usage.FreeDataBlocks
+	metadataUsed := usage.TotalMetadataBlocks - usage.FreeMetadataBlocks
+
+	ch <- prometheus.MustNewConstMetric(c.dataUsageBytes, prometheus.GaugeValue, float64(dataUsed*c.blockSizeBytes), c.poolName)
+	ch <- prometheus.MustNewConstMetric(c.dataUsagePercent, prometheus.GaugeValue, 100-usage.DataPercentFree(), c.poolName)
+	ch <- prometheus.MustNewConstMetric(c.metadataUsageBytes, prometheus.GaugeValue, float64(metadataUsed*devmapper.DMThinMetadataBlockSize), c.poolName)
+	ch <- prometheus.MustNewConstMetric(c.metadataUsagePercent, prometheus.GaugeValue, 100-usage.MetadataPercentFree(), c.poolName)
+	ch <- prometheus.MustNewConstMetric(c.transactionID, prometheus.GaugeValue, float64(usage.TransactionID), c.poolName)
+
+	if c.config != nil {
+		ch <- prometheus.MustNewConstMetric(c.metadataReservedBytes, prometheus.GaugeValue, float64(c.config.ReserveMetadataBytes()), c.poolName)
+	}
+}
@@ -0,0 +1,382 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package devmapper
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrPoolReadOnly is returned when the thin-pool has been switched to
+	// read-only mode by the kernel, which happens when it runs out of
+	// metadata space and requires an offline repair.
+	ErrPoolReadOnly = errors.New("devmapper: thin-pool is read-only")
+
+	// ErrPoolOutOfDataSpace is returned when the thin-pool has no free data
+	// blocks left to satisfy an allocation.
+	ErrPoolOutOfDataSpace = errors.New("devmapper: thin-pool is out of data space")
+)
+
+// PoolUsage is a point-in-time snapshot of a thin-pool's data and metadata
+// space utilization, as reported by `dmsetup status`.
+type PoolUsage struct {
+	FreeDataBlocks      uint64
+	TotalDataBlocks     uint64
+	FreeMetadataBlocks  uint64
+	TotalMetadataBlocks uint64
+	TransactionID       uint64
+}
+
+// DataPercentFree returns the percentage (0-100) of data blocks that are
+// still free. A pool with no data blocks reports 100.
+func (u PoolUsage) DataPercentFree() float64 {
+	if u.TotalDataBlocks == 0 {
+		return 100
+	}
+	return float64(u.FreeDataBlocks) / float64(u.TotalDataBlocks) * 100
+}
+
+// MetadataPercentFree returns the percentage (0-100) of metadata blocks that
+// are still free. A pool with no metadata blocks reports 100.
+func (u PoolUsage) MetadataPercentFree() float64 {
+	if u.TotalMetadataBlocks == 0 {
+		return 100
+	}
+	return float64(u.FreeMetadataBlocks) / float64(u.TotalMetadataBlocks) * 100
+}
+
+// poolHealth is the third field of a thin-pool's dmsetup status line.
+type poolHealth string
+
+const (
+	poolHealthReadWrite      poolHealth = "rw"
+	poolHealthReadOnly       poolHealth = "ro"
+	poolHealthOutOfDataSpace poolHealth = "out_of_data_space"
+)
+
+// ThinPoolWatcher periodically polls a thin-pool's usage via `dmsetup
+// status`, caches the result, and, on its own background polling tick,
+// synchronously extends the pool's backing device when free space drops
+// below the configured auto-extend thresholds. Reading usage via GetUsage
+// never extends the pool itself, so attaching a Prometheus scraper or any
+// other reader cannot trigger a mutation as a side effect of observing it.
+//
+// This mirrors how cadvisor tracks devicemapper pool usage, so that
+// devmapper-backed snapshotters don't depend on an external monitor to stay
+// out of out-of-space conditions.
+type ThinPoolWatcher struct {
+	poolName string
+	interval time.Duration
+	config   *ConfigLoader
+
+	// GrowBackingDevice is invoked before reloading the pool table when an
+	// auto-extend threshold has been crossed. kind is "data" or "metadata"
+	// and percent is the configured growth increment. It is environment
+	// specific (loop file vs. block device) and must be supplied by the
+	// caller; extension is skipped with an error if it is nil.
+	GrowBackingDevice func(ctx context.Context, kind string, percent int) error
+
+	mu        sync.Mutex
+	usage     PoolUsage
+	haveUsage bool
+	lastErr   error
+
+	refreshMu  sync.Mutex
+	refreshing bool
+	refreshCh  chan struct{}
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stopCh    chan struct{}
+	doneCh    chan struct{}
+}
+
+// NewThinPoolWatcher creates a watcher for the given thin-pool device. Call
+// Start to begin polling on the configured interval; GetUsage can be called
+// at any time, before or after Start, and will probe synchronously if no
+// cached value exists yet. config is read through its accessor methods on
+// every probe, so changes applied via ConfigLoader.Reload take effect on
+// the next poll without recreating the watcher.
+func NewThinPoolWatcher(poolName string, config *ConfigLoader, interval time.Duration) *ThinPoolWatcher {
+	return &ThinPoolWatcher{
+		poolName: poolName,
+		config:   config,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+}
+
+// Start begins the background polling loop. It is safe to call multiple
+// times; only the first call has an effect.
+func (w *ThinPoolWatcher) Start() {
+	w.startOnce.Do(func() {
+		go w.run()
+	})
+}
+
+// Stop terminates the polling goroutine and waits for it to exit. It is safe
+// to call Stop without having called Start.
+func (w *ThinPoolWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+	})
+	// If Start was never called, no goroutine will close doneCh; close it
+	// here so Stop returns and a later Start becomes a no-op.
+	w.startOnce.Do(func() {
+		close(w.doneCh)
+	})
+	<-w.doneCh
+}
+
+func (w *ThinPoolWatcher) run() {
+	defer close(w.doneCh)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.tick()
+		case <-w.stopCh:
+			return
+		}
+	}
+}
+
+// tick runs one poll-and-maybe-extend cycle: it refreshes the cached usage
+// and, unlike GetUsage, is allowed to synchronously extend the pool when an
+// auto-extend threshold has been crossed. Errors are cached and surfaced to
+// the next GetUsage caller; the polling loop itself just keeps going.
+func (w *ThinPoolWatcher) tick() {
+	ctx := context.Background()
+
+	usage, err := w.refresh(ctx)
+	if err != nil || w.config == nil {
+		return
+	}
+
+	if err := w.maybeExtend(ctx, usage); err != nil {
+		w.mu.Lock()
+		w.lastErr = errors.Wrap(err, "failed to auto-extend pool")
+		w.mu.Unlock()
+	}
+}
+
+// GetUsage returns the most recently observed pool usage. It never
+// triggers a pool extension: auto-extension only happens on the background
+// polling tick, so that reading usage (including via a Prometheus scrape)
+// is side-effect free. If no probe has completed yet, it queries the pool
+// once, synchronously, to populate the cache.
+func (w *ThinPoolWatcher) GetUsage(ctx context.Context) (PoolUsage, error) {
+	w.mu.Lock()
+	haveUsage := w.haveUsage
+	usage, err := w.usage, w.lastErr
+	w.mu.Unlock()
+
+	if haveUsage {
+		return usage, err
+	}
+
+	return w.refresh(ctx)
+}
+
+// refresh runs a single-flight probe of the pool: if a probe is already in
+// flight, callers wait for it to finish and share its result instead of
+// issuing their own `dmsetup status`. It only queries and caches usage; it
+// never triggers auto-extension.
+func (w *ThinPoolWatcher) refresh(ctx context.Context) (PoolUsage, error) {
+	w.refreshMu.Lock()
+	if w.refreshing {
+		done := w.refreshCh
+		w.refreshMu.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return PoolUsage{}, ctx.Err()
+		}
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.usage, w.lastErr
+	}
+
+	w.refreshing = true
+	done := make(chan struct{})
+	w.refreshCh = done
+	w.refreshMu.Unlock()
+
+	usage, err := w.queryStatus(ctx)
+
+	w.mu.Lock()
+	if err == nil || errors.Cause(err) == ErrPoolReadOnly || errors.Cause(err) == ErrPoolOutOfDataSpace {
+		w.usage = usage
+		w.haveUsage = true
+	}
+	w.lastErr = err
+	w.mu.Unlock()
+
+	w.refreshMu.Lock()
+	w.refreshing = false
+	w.refreshCh = nil
+	w.refreshMu.Unlock()
+	close(done)
+
+	return usage, err
+}
+
+// queryStatus runs `dmsetup status` for the pool and parses the result. It
+// is a pure read: it never mutates pool state.
+func (w *ThinPoolWatcher) queryStatus(ctx context.Context) (PoolUsage, error) {
+	cmd := exec.CommandContext(ctx, "dmsetup", "status", w.poolName)
+	out, err := cmd.Output()
+	if err != nil {
+		return PoolUsage{}, errors.Wrapf(err, "failed to query status for pool %q", w.poolName)
+	}
+
+	usage, health, err := parseThinPoolStatus(string(out))
+	if err != nil {
+		return PoolUsage{}, errors.Wrapf(err, "failed to parse dmsetup status for pool %q", w.poolName)
+	}
+
+	switch health {
+	case poolHealthReadOnly:
+		return usage, ErrPoolReadOnly
+	case poolHealthOutOfDataSpace:
+		return usage, ErrPoolOutOfDataSpace
+	}
+
+	return usage, nil
+}
+
+// maybeExtend triggers a synchronous pool extension for whichever of data
+// or metadata space has fallen below its configured threshold.
+func (w *ThinPoolWatcher) maybeExtend(ctx context.Context, usage PoolUsage) error {
+	dataThreshold, dataPercent := w.config.DataAutoExtend()
+	if dataThreshold > 0 && usage.DataPercentFree() < float64(dataThreshold) {
+		if err := w.extend(ctx, "data", dataPercent); err != nil {
+			return err
+		}
+	}
+
+	metadataThreshold, metadataPercent := w.config.MetadataAutoExtend()
+	if metadataThreshold > 0 && usage.MetadataPercentFree() < float64(metadataThreshold) {
+		if err := w.extend(ctx, "metadata", metadataPercent); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extend grows the pool's backing device by percent and asks the kernel to
+// pick up the new size.
+func (w *ThinPoolWatcher) extend(ctx context.Context, kind string, percent int) error {
+	if percent <= 0 {
+		return errors.Errorf("%s_auto_extend_percent must be > 0 to auto-extend %s space", kind, kind)
+	}
+
+	if w.GrowBackingDevice == nil {
+		return errors.Errorf("no backing device grow hook configured for pool %q", w.poolName)
+	}
+
+	if err := w.GrowBackingDevice(ctx, kind, percent); err != nil {
+		return errors.Wrapf(err, "failed to grow backing device for %s space", kind)
+	}
+
+	cmd := exec.CommandContext(ctx, "dmsetup", "message", w.poolName, "0", "resize")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "dmsetup message resize failed: %s", strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// parseThinPoolStatus parses the status line of a dm thin-pool target, as
+// documented in Documentation/admin-guide/device-mapper/thin-provisioning.rst:
+//
+//	<start> <length> thin-pool <transaction id> <used metadata blocks>/<total
+//	metadata blocks> <used data blocks>/<total data blocks> <held metadata
+//	root> ro|rw|out_of_data_space [options...]
+func parseThinPoolStatus(status string) (PoolUsage, poolHealth, error) {
+	fields := strings.Fields(status)
+	if len(fields) < 8 || fields[2] != "thin-pool" {
+		return PoolUsage{}, "", errors.Errorf("unrecognized thin-pool status: %q", status)
+	}
+
+	transactionID, err := strconv.ParseUint(fields[3], 10, 64)
+	if err != nil {
+		return PoolUsage{}, "", errors.Wrap(err, "failed to parse transaction id")
+	}
+
+	usedMeta, totalMeta, err := parseBlockFraction(fields[4])
+	if err != nil {
+		return PoolUsage{}, "", errors.Wrap(err, "failed to parse metadata block usage")
+	}
+
+	usedData, totalData, err := parseBlockFraction(fields[5])
+	if err != nil {
+		return PoolUsage{}, "", errors.Wrap(err, "failed to parse data block usage")
+	}
+
+	usage := PoolUsage{
+		FreeDataBlocks:      totalData - usedData,
+		TotalDataBlocks:     totalData,
+		FreeMetadataBlocks:  totalMeta - usedMeta,
+		TotalMetadataBlocks: totalMeta,
+		TransactionID:       transactionID,
+	}
+
+	switch fields[7] {
+	case "ro":
+		return usage, poolHealthReadOnly, nil
+	case "out_of_data_space":
+		return usage, poolHealthOutOfDataSpace, nil
+	default:
+		return usage, poolHealthReadWrite, nil
+	}
+}
+
+func parseBlockFraction(field string) (used, total uint64, err error) {
+	parts := strings.SplitN(field, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("expected <used>/<total>, got %q", field)
+	}
+
+	used, err = strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	total, err = strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return used, total, nil
+}
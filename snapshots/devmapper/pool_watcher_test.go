@@ -0,0 +1,145 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package devmapper
+
+import "testing"
+
+func TestParseThinPoolStatus(t *testing.T) {
+	for _, testcase := range []struct {
+		name       string
+		status     string
+		wantHealth poolHealth
+		wantUsage  PoolUsage
+		wantErr    bool
+	}{
+		{
+			name:       "read-write",
+			status:     "0 204800 thin-pool 1 128/4096 1024/204800 - rw discard_passdown queue_if_no_space",
+			wantHealth: poolHealthReadWrite,
+			wantUsage: PoolUsage{
+				FreeDataBlocks:      204800 - 1024,
+				TotalDataBlocks:     204800,
+				FreeMetadataBlocks:  4096 - 128,
+				TotalMetadataBlocks: 4096,
+				TransactionID:       1,
+			},
+		},
+		{
+			name:       "read-only",
+			status:     "0 204800 thin-pool 7 4000/4096 204800/204800 - ro discard_passdown queue_if_no_space",
+			wantHealth: poolHealthReadOnly,
+			wantUsage: PoolUsage{
+				FreeDataBlocks:      0,
+				TotalDataBlocks:     204800,
+				FreeMetadataBlocks:  96,
+				TotalMetadataBlocks: 4096,
+				TransactionID:       7,
+			},
+		},
+		{
+			name:       "out of data space",
+			status:     "0 204800 thin-pool 7 128/4096 204800/204800 - out_of_data_space queue_if_no_space",
+			wantHealth: poolHealthOutOfDataSpace,
+			wantUsage: PoolUsage{
+				FreeDataBlocks:      0,
+				TotalDataBlocks:     204800,
+				FreeMetadataBlocks:  3968,
+				TotalMetadataBlocks: 4096,
+				TransactionID:       7,
+			},
+		},
+		{
+			name:    "not a thin-pool target",
+			status:  "0 204800 linear 0",
+			wantErr: true,
+		},
+		{
+			name:    "too few fields",
+			status:  "0 204800 thin-pool 1 128/4096",
+			wantErr: true,
+		},
+		{
+			name:    "malformed block fraction",
+			status:  "0 204800 thin-pool 1 128 1024/204800 - rw",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			status:  "",
+			wantErr: true,
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			usage, health, err := parseThinPoolStatus(testcase.status)
+			if testcase.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got usage=%+v health=%q", usage, health)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if health != testcase.wantHealth {
+				t.Errorf("health = %q, want %q", health, testcase.wantHealth)
+			}
+
+			if usage != testcase.wantUsage {
+				t.Errorf("usage = %+v, want %+v", usage, testcase.wantUsage)
+			}
+		})
+	}
+}
+
+func TestParseBlockFraction(t *testing.T) {
+	for _, testcase := range []struct {
+		name      string
+		field     string
+		wantUsed  uint64
+		wantTotal uint64
+		wantErr   bool
+	}{
+		{name: "basic", field: "128/4096", wantUsed: 128, wantTotal: 4096},
+		{name: "zero used", field: "0/4096", wantUsed: 0, wantTotal: 4096},
+		{name: "missing slash", field: "1284096", wantErr: true},
+		{name: "non-numeric used", field: "abc/4096", wantErr: true},
+		{name: "non-numeric total", field: "128/abc", wantErr: true},
+		{name: "too many parts", field: "1/2/3", wantErr: true},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			used, total, err := parseBlockFraction(testcase.field)
+			if testcase.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got used=%d total=%d", used, total)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if used != testcase.wantUsed || total != testcase.wantTotal {
+				t.Errorf("got used=%d total=%d, want used=%d total=%d", used, total, testcase.wantUsed, testcase.wantTotal)
+			}
+		})
+	}
+}
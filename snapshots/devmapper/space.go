@@ -0,0 +1,97 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package devmapper
+
+import (
+	"fmt"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+// DMThinMetadataBlockSize is the fixed metadata block size used by the dm
+// thin-pool target, in bytes. It is not configurable by dm-thin itself.
+const DMThinMetadataBlockSize = 4096
+
+// ErrPoolLowSpace is returned by CheckSpace when allocating a new thin
+// device or snapshot would push the pool's free data or metadata space
+// below the configured minimum, or into the metadata headroom reserved for
+// deletion/commit operations. It wraps errdefs.ErrResourceExhausted so
+// callers can detect it with errdefs.IsResourceExhausted.
+type ErrPoolLowSpace struct {
+	// Kind is "data" or "metadata".
+	Kind  string
+	Usage PoolUsage
+}
+
+func (e *ErrPoolLowSpace) Error() string {
+	return fmt.Sprintf("devmapper: insufficient free %s space in pool", e.Kind)
+}
+
+// Cause implements the github.com/pkg/errors causer interface so that
+// errdefs.IsResourceExhausted(err) reports true for ErrPoolLowSpace.
+func (e *ErrPoolLowSpace) Cause() error {
+	return errdefs.ErrResourceExhausted
+}
+
+// CheckSpace verifies that the pool has enough free data and metadata space,
+// including the configured reserve, to satisfy a pending allocation of
+// requestedBytes (for a new thin device or snapshot) without pushing free
+// space below the configured minimums. dataBlockSizeBytes is the pool's
+// data block size, used to convert requestedBytes into data blocks. It
+// should be consulted against the pool watcher's cached usage before every
+// allocation, so the snapshotter fails fast instead of blocking on an
+// allocation the pool cannot satisfy. config is read through ConfigLoader
+// so a Reload of min_free_space_percent takes effect immediately.
+func CheckSpace(config *ConfigLoader, usage PoolUsage, requestedBytes, dataBlockSizeBytes uint64) error {
+	requestedBlocks := (requestedBytes + dataBlockSizeBytes - 1) / dataBlockSizeBytes
+	if requestedBlocks > usage.FreeDataBlocks {
+		return &ErrPoolLowSpace{Kind: "data", Usage: usage}
+	}
+
+	remaining := usage
+	remaining.FreeDataBlocks -= requestedBlocks
+	if remaining.DataPercentFree() < float64(config.MinFreeSpacePercent()) {
+		return &ErrPoolLowSpace{Kind: "data", Usage: usage}
+	}
+
+	if usage.MetadataPercentFree() < float64(config.MetadataMinFreeSpacePercent()) {
+		return &ErrPoolLowSpace{Kind: "metadata", Usage: usage}
+	}
+
+	if reserve := config.ReserveMetadataBytes(); reserve > 0 {
+		freeMetadataBytes := usage.FreeMetadataBlocks * DMThinMetadataBlockSize
+		if freeMetadataBytes < reserve {
+			return &ErrPoolLowSpace{Kind: "metadata", Usage: usage}
+		}
+	}
+
+	return nil
+}
+
+// ValidateReserve checks ReserveMetadataBytes against the pool's actual
+// total metadata size. It can only run once the pool has been opened and
+// its total metadata block count is known, unlike Validate which only
+// checks values available at config load time.
+func (c *Config) ValidateReserve(totalMetadataBlocks uint64) error {
+	totalMetadataBytes := totalMetadataBlocks * DMThinMetadataBlockSize
+	if c.ReserveMetadataBytes > totalMetadataBytes {
+		return fmt.Errorf("reserve_metadata_bytes (%d) exceeds pool's total metadata size (%d)", c.ReserveMetadataBytes, totalMetadataBytes)
+	}
+	return nil
+}
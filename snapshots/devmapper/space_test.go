@@ -0,0 +1,153 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package devmapper
+
+import "testing"
+
+func newTestConfigLoader(c Config) *ConfigLoader {
+	return &ConfigLoader{current: &c}
+}
+
+func TestCheckSpace(t *testing.T) {
+	const dataBlockSize = 64 * 1024 // 64KiB, a typical thin-pool data block size
+
+	usage := PoolUsage{
+		FreeDataBlocks:      1000,
+		TotalDataBlocks:     10000, // 10% free
+		FreeMetadataBlocks:  1000,
+		TotalMetadataBlocks: 4096,
+	}
+
+	for _, testcase := range []struct {
+		name           string
+		config         Config
+		requestedBytes uint64
+		wantErr        bool
+	}{
+		{
+			name:           "small allocation within min_free_space_percent",
+			config:         Config{MinFreeSpacePercent: 5, MetadataMinFreeSpacePercent: 5},
+			requestedBytes: dataBlockSize, // 1 block
+		},
+		{
+			name:           "allocation would cross min_free_space_percent",
+			config:         Config{MinFreeSpacePercent: 9, MetadataMinFreeSpacePercent: 5},
+			requestedBytes: 200 * dataBlockSize, // leaves 800/10000 = 8% free, below 9%
+			wantErr:        true,
+		},
+		{
+			name:           "allocation exceeds all free data blocks",
+			config:         Config{MinFreeSpacePercent: 5, MetadataMinFreeSpacePercent: 5},
+			requestedBytes: 1001 * dataBlockSize,
+			wantErr:        true,
+		},
+		{
+			name:           "metadata below minimum",
+			config:         Config{MinFreeSpacePercent: 5, MetadataMinFreeSpacePercent: 50},
+			requestedBytes: dataBlockSize,
+			wantErr:        true,
+		},
+		{
+			name:           "metadata reserve not satisfied",
+			config:         Config{MinFreeSpacePercent: 5, MetadataMinFreeSpacePercent: 5, ReserveMetadataBytes: 1001 * DMThinMetadataBlockSize},
+			requestedBytes: dataBlockSize,
+			wantErr:        true,
+		},
+		{
+			name:           "metadata reserve satisfied",
+			config:         Config{MinFreeSpacePercent: 5, MetadataMinFreeSpacePercent: 5, ReserveMetadataBytes: 100 * DMThinMetadataBlockSize},
+			requestedBytes: dataBlockSize,
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			err := CheckSpace(newTestConfigLoader(testcase.config), usage, testcase.requestedBytes, dataBlockSize)
+			if testcase.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got nil")
+				}
+				if _, ok := err.(*ErrPoolLowSpace); !ok {
+					t.Fatalf("expected *ErrPoolLowSpace, got %T: %v", err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestConfigValidateReserve(t *testing.T) {
+	for _, testcase := range []struct {
+		name                string
+		reserveBytes        uint64
+		totalMetadataBlocks uint64
+		wantErr             bool
+	}{
+		{name: "reserve within total", reserveBytes: 100 * DMThinMetadataBlockSize, totalMetadataBlocks: 4096},
+		{name: "reserve equals total", reserveBytes: 4096 * DMThinMetadataBlockSize, totalMetadataBlocks: 4096},
+		{name: "reserve exceeds total", reserveBytes: 4097 * DMThinMetadataBlockSize, totalMetadataBlocks: 4096, wantErr: true},
+		{name: "no reserve configured", reserveBytes: 0, totalMetadataBlocks: 0},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			c := Config{ReserveMetadataBytes: testcase.reserveBytes}
+			err := c.ValidateReserve(testcase.totalMetadataBlocks)
+			if testcase.wantErr && err == nil {
+				t.Fatal("expected an error, got nil")
+			}
+			if !testcase.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestConfigValidateFreeSpacePercentRanges(t *testing.T) {
+	base := Config{
+		PoolName:      "pool",
+		RootPath:      "/root",
+		BaseImageSize: "8GB",
+	}
+
+	for _, testcase := range []struct {
+		name    string
+		mutate  func(c *Config)
+		wantErr bool
+	}{
+		{name: "defaults are valid", mutate: func(c *Config) {}},
+		{name: "min_free_space_percent at upper bound", mutate: func(c *Config) { c.MinFreeSpacePercent = 99 }},
+		{name: "min_free_space_percent too high", mutate: func(c *Config) { c.MinFreeSpacePercent = 100 }, wantErr: true},
+		{name: "min_free_space_percent negative", mutate: func(c *Config) { c.MinFreeSpacePercent = -1 }, wantErr: true},
+		{name: "metadata_min_free_space_percent too high", mutate: func(c *Config) { c.MetadataMinFreeSpacePercent = 100 }, wantErr: true},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			c := base
+			testcase.mutate(&c)
+
+			err := c.Validate()
+			if testcase.wantErr && err == nil {
+				t.Fatal("expected an error, got nil")
+			}
+			if !testcase.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}